@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/erickfaustino/urd/sources"
+	"github.com/erickfaustino/urd/sources/aws"
+)
+
+// metricDef binds a canonical sources.MetricKey to the Prometheus vector it
+// feeds and how to publish a fresh datapoint to it or prune a departed
+// Service's label combination from it. Every MetricSource maps its own
+// backend's metrics onto this same canonical set, which is what lets the
+// collector stay cloud-agnostic: it only ever deals in sources.MetricKey.
+type metricDef struct {
+	publish func(c *ELBCollector, lb sources.LoadBalancer, value float64)
+	prune   func(c *ELBCollector, lb sources.LoadBalancer)
+}
+
+// canonicalMetrics maps every MetricKey urd knows about to the Prometheus
+// vector it belongs to. A MetricSource is free to support any subset of
+// these; metrics it doesn't return are simply never published.
+var canonicalMetrics = map[sources.MetricKey]metricDef{
+	sources.MetricHTTP2xx: httpStatusMetric("2XX"),
+	sources.MetricHTTP3xx: httpStatusMetric("3XX"),
+	sources.MetricHTTP4xx: httpStatusMetric("4XX"),
+	sources.MetricHTTP5xx: httpStatusMetric("5XX"),
+	sources.MetricELB4xx:  httpStatusMetric("ELB_4XX"),
+	sources.MetricELB5xx:  httpStatusMetric("ELB_5XX"),
+	sources.MetricBackendErrors: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.backendConnectionsErrors.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.backendConnectionsErrors.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricHealthyHostCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.healthyHostCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Set(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.healthyHostCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricUnhealthyHostCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.unhealthyHostCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Set(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.unhealthyHostCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricLatency: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.latencyVecFor(lb.Type).WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Observe(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.latencyVecFor(lb.Type).DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricRequestCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.requestCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.requestCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricSpilloverCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.spilloverCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.spilloverCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricSurgeQueueLength: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.surgeQueueLength.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.surgeQueueLength.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricRejectedConnections: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.rejectedConnectionCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.rejectedConnectionCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricActiveFlowCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.activeFlowCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Set(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.activeFlowCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricNewFlowCount: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.newFlowCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.newFlowCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricProcessedBytes: {
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.processedBytes.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.processedBytes.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	},
+	sources.MetricTCPResetClient: tcpResetMetric("client"),
+	sources.MetricTCPResetELB:    tcpResetMetric("elb"),
+	sources.MetricTCPResetTarget: tcpResetMetric("target"),
+}
+
+// httpStatusMetric returns the metricDef for an HTTP status class, all of
+// which share the httpRequestsTotal vector and differ only by the "status"
+// label value.
+func httpStatusMetric(status string) metricDef {
+	return metricDef{
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.httpRequestsTotal.WithLabelValues(status, lb.Name, lb.SvcName, lb.Namespace, lb.Type).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.httpRequestsTotal.DeleteLabelValues(status, lb.Name, lb.SvcName, lb.Namespace, lb.Type)
+		},
+	}
+}
+
+// tcpResetMetric returns the metricDef for a TCP reset origin, all of which
+// share the tcpResetCount vector and differ only by the "reset_type" label
+// value.
+func tcpResetMetric(resetType string) metricDef {
+	return metricDef{
+		publish: func(c *ELBCollector, lb sources.LoadBalancer, value float64) {
+			c.tcpResetCount.WithLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type, resetType).Add(value)
+		},
+		prune: func(c *ELBCollector, lb sources.LoadBalancer) {
+			c.tcpResetCount.DeleteLabelValues(lb.Name, lb.SvcName, lb.Namespace, lb.Type, resetType)
+		},
+	}
+}
+
+// ELBCollector is a prometheus.Collector that serves load balancer metrics
+// for the Services that are live at scrape time. It fetches fresh
+// datapoints from its MetricSource at most once per scrapeInterval and
+// serves the last fetched values on every Collect call in between, so a
+// Prometheus scrape cadence faster than scrapeInterval doesn't multiply
+// API calls to the underlying cloud.
+type ELBCollector struct {
+	provider sources.Provider
+	src      sources.MetricSource
+
+	scrapeInterval        time.Duration
+	scrapeTimeout         time.Duration
+	maxConcurrentRequests int
+
+	httpRequestsTotal        *prometheus.CounterVec
+	backendConnectionsErrors *prometheus.CounterVec
+	healthyHostCount         *prometheus.GaugeVec
+	elbLatency               *prometheus.HistogramVec
+	requestCount             *prometheus.CounterVec
+	spilloverCount           *prometheus.CounterVec
+	surgeQueueLength         *prometheus.CounterVec
+	unhealthyHostCount       *prometheus.GaugeVec
+	targetResponseTime       *prometheus.HistogramVec
+	rejectedConnectionCount  *prometheus.CounterVec
+	activeFlowCount          *prometheus.GaugeVec
+	newFlowCount             *prometheus.CounterVec
+	processedBytes           *prometheus.CounterVec
+	tcpResetCount            *prometheus.CounterVec
+	scrapeDurationSeconds    prometheus.Histogram
+	elbInfo                  *prometheus.GaugeVec
+
+	includeTags []string
+
+	mu        sync.Mutex
+	lastFetch time.Time
+
+	servicesMu sync.RWMutex
+	services   map[string]serviceRecord
+}
+
+// NewELBCollector creates an ELBCollector that sources metrics from src for
+// Services backed by a LoadBalancer on provider, and all of the Prometheus
+// vectors it serves. includeTags is the allow-list of cloud resource tags
+// (currently AWS only, see sources/aws.Resolver) that get turned into
+// tag_<key> labels on urd_elb_info. It must be registered with
+// prometheus.MustRegister before any metrics will be exposed; if src also
+// implements prometheus.Collector (as sources/aws.Source does, for its own
+// self-observability counters), register it too. scrapeTimeout bounds how
+// long a single refresh's cloud API calls may run before they're
+// abandoned, so a stalled call can't block every scrape behind it forever.
+func NewELBCollector(provider sources.Provider, src sources.MetricSource, scrapeInterval, scrapeTimeout time.Duration, maxConcurrentRequests int, includeTags []string) *ELBCollector {
+	elbInfoLabels := []string{"elb_name", "svc_name", "namespace", "lb_type"}
+	for _, tag := range includeTags {
+		elbInfoLabels = append(elbInfoLabels, aws.SanitizeTagLabel(tag))
+	}
+
+	return &ELBCollector{
+		provider:                 provider,
+		src:                      src,
+		scrapeInterval:           scrapeInterval,
+		scrapeTimeout:            scrapeTimeout,
+		maxConcurrentRequests:    maxConcurrentRequests,
+		includeTags:              includeTags,
+		elbInfo:                  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_elb_info", Help: "Always 1; carries the load balancer's allow-listed cloud tags as labels (see --include-tag)"}, elbInfoLabels),
+		httpRequestsTotal:        prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_http_requests_total", Help: "Total of HTTP Requests"}, []string{"status", "elb_name", "svc_name", "namespace", "lb_type"}),
+		backendConnectionsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "backend_connection_errors_total", Help: "Total of Backend connection errors"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		healthyHostCount:         prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_healthy_hosts_count", Help: "The number of healthy instances registered with load balance"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		elbLatency:               prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "urd_average_elb_latency", Help: "Average latency in seconds from ELB sent the request to a instance until instance starts to respond"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		requestCount:             prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_request_count", Help: "Total of requests in the last interval (60 seconds by default)"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		spilloverCount:           prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_spillovercount_total", Help: "The total number of requests that were rejected because the surge queue is full."}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		surgeQueueLength:         prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_surge_queue_length", Help: "The total number of requests that are pending routing"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		unhealthyHostCount:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_unhealthy_hosts_count", Help: "The number of unhealthy instances registered with load balancer"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		targetResponseTime:       prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "urd_target_response_time", Help: "Average response time in seconds from an ALB target to respond"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		rejectedConnectionCount:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_rejected_connection_count_total", Help: "The number of connections rejected because the ALB had reached its maximum number of connections"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		activeFlowCount:          prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_active_flow_count", Help: "The total number of concurrent flows (or connections) from clients to targets behind the NLB"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		newFlowCount:             prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_new_flow_count_total", Help: "The total number of new flows (or connections) established from clients to targets behind the NLB"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		processedBytes:           prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_processed_bytes_total", Help: "The total number of bytes processed by the NLB"}, []string{"elb_name", "svc_name", "namespace", "lb_type"}),
+		tcpResetCount:            prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_tcp_reset_count_total", Help: "The total number of TCP RST packets generated, broken down by origin"}, []string{"elb_name", "svc_name", "namespace", "lb_type", "reset_type"}),
+		scrapeDurationSeconds:    prometheus.NewHistogram(prometheus.HistogramOpts{Name: "urd_scrape_duration_seconds", Help: "Time taken to complete a full scrape of all load balancers"}),
+	}
+}
+
+// latencyVecFor returns the histogram a latency datapoint belongs to:
+// Classic ELBs keep the original urd_average_elb_latency metric name,
+// everything else (ALB/NLB and the GCP/Azure equivalents) reports under
+// urd_target_response_time.
+func (c *ELBCollector) latencyVecFor(lbType string) *prometheus.HistogramVec {
+	if lbType == aws.TypeClassic {
+		return c.elbLatency
+	}
+	return c.targetResponseTime
+}
+
+// vecs returns every Prometheus collector this ELBCollector wraps, so
+// Describe and Collect can forward to them without repeating the list twice.
+func (c *ELBCollector) vecs() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.httpRequestsTotal,
+		c.backendConnectionsErrors,
+		c.healthyHostCount,
+		c.elbLatency,
+		c.requestCount,
+		c.spilloverCount,
+		c.surgeQueueLength,
+		c.unhealthyHostCount,
+		c.targetResponseTime,
+		c.rejectedConnectionCount,
+		c.activeFlowCount,
+		c.newFlowCount,
+		c.processedBytes,
+		c.tcpResetCount,
+		c.scrapeDurationSeconds,
+		c.elbInfo,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *ELBCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, v := range c.vecs() {
+		v.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector. It refreshes from the
+// MetricSource at most once per scrapeInterval for the Services that are
+// live at the time of the refresh, then serves whatever was last fetched.
+func (c *ELBCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	if c.lastFetch.IsZero() || time.Since(c.lastFetch) >= c.scrapeInterval {
+		c.refresh()
+		c.lastFetch = time.Now()
+	}
+	c.mu.Unlock()
+
+	for _, v := range c.vecs() {
+		v.Collect(ch)
+	}
+}
+
+// metricsFor returns the canonical metric keys the collector should query
+// for a LoadBalancer of the given type: whatever c.src advertises via
+// MetricLister, or every known key if it doesn't implement that optional
+// interface.
+func (c *ELBCollector) metricsFor(lbType string) []sources.MetricKey {
+	if lister, ok := c.src.(sources.MetricLister); ok {
+		return lister.Metrics(lbType)
+	}
+	return sources.AllMetricKeys()
+}
+
+// refresh fetches a fresh datapoint from the MetricSource for every metric
+// of every Service the watch-fed index currently considers live.
+// Callers must hold c.mu.
+func (c *ELBCollector) refresh() {
+	begin := time.Now()
+	records := c.liveServices()
+
+	queries := make([]sources.Query, 0, len(records))
+	var nextID int
+	for _, r := range records {
+		for _, metric := range r.Metrics {
+			queries = append(queries, sources.Query{
+				ID:     fmt.Sprintf("q%d", nextID),
+				LB:     r.LB,
+				Metric: metric,
+			})
+			nextID++
+		}
+	}
+
+	byID := make(map[string]sources.Query, len(queries))
+	for _, q := range queries {
+		byID[q.ID] = q
+	}
+
+	start, end := begin.Add(-c.scrapeInterval), begin
+	ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTimeout)
+	defer cancel()
+
+	if batcher, ok := c.src.(sources.BatchFetcher); ok {
+		values, err := batcher.FetchBatch(ctx, queries, start, end)
+		if err != nil {
+			log.Printf("FetchBatch: %v", err)
+		} else {
+			for id, value := range values {
+				c.publish(byID[id], value)
+			}
+		}
+	} else {
+		c.fetchEach(ctx, queries, start, end)
+	}
+
+	c.scrapeDurationSeconds.Observe(time.Since(begin).Seconds())
+}
+
+// fetchEach issues one Fetch call per query, bounded by
+// c.maxConcurrentRequests in flight at once. It's the fallback path for a
+// MetricSource that doesn't implement sources.BatchFetcher.
+func (c *ELBCollector) fetchEach(ctx context.Context, queries []sources.Query, start, end time.Time) {
+	sem := make(chan struct{}, c.maxConcurrentRequests)
+	var wg sync.WaitGroup
+	wg.Add(len(queries))
+	for _, q := range queries {
+		sem <- struct{}{}
+		go func(q sources.Query) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			value, err := c.src.Fetch(ctx, q.LB, q.Metric, start, end)
+			if err != nil {
+				log.Printf("Fetch: %v", err)
+				return
+			}
+			c.publish(q, value)
+		}(q)
+	}
+	wg.Wait()
+}
+
+// publish routes a fetched datapoint to the Prometheus vector its
+// MetricKey belongs to.
+func (c *ELBCollector) publish(q sources.Query, value float64) {
+	def, ok := canonicalMetrics[q.Metric]
+	if !ok {
+		return
+	}
+	def.publish(c, q.LB, value)
+}
+
+// elbInfoLabelValues returns the label values for lb on c.elbInfo, in the
+// same order the vector was constructed with: the fixed labels followed by
+// one value per c.includeTags entry, pulled from lb.Tags (empty if the
+// MetricSource didn't resolve that tag).
+func (c *ELBCollector) elbInfoLabelValues(lb sources.LoadBalancer) []string {
+	values := []string{lb.Name, lb.SvcName, lb.Namespace, lb.Type}
+	for _, tag := range c.includeTags {
+		values = append(values, lb.Tags[tag])
+	}
+	return values
+}
+
+// publishELBInfo sets the urd_elb_info series for lb to 1.
+func (c *ELBCollector) publishELBInfo(lb sources.LoadBalancer) {
+	c.elbInfo.WithLabelValues(c.elbInfoLabelValues(lb)...).Set(1)
+}
+
+// pruneELBInfo removes lb's urd_elb_info series.
+func (c *ELBCollector) pruneELBInfo(lb sources.LoadBalancer) {
+	c.elbInfo.DeleteLabelValues(c.elbInfoLabelValues(lb)...)
+}
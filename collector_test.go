@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/erickfaustino/urd/sources"
+	"github.com/erickfaustino/urd/sources/aws"
+)
+
+// wantDesc is the descriptor ELBCollector must register for one metric: the
+// label names every series for that metric must carry, and its help text.
+type wantDesc struct {
+	labels []string
+	help   string
+}
+
+// wantDescs is the stable set of metric descriptors ELBCollector must
+// register, keyed by metric name. This test exists to catch accidental
+// drift (renamed/dropped labels, edited help text) between releases.
+var wantDescs = map[string]wantDesc{
+	"urd_http_requests_total":             {[]string{"status", "elb_name", "svc_name", "namespace", "lb_type"}, "Total of HTTP Requests"},
+	"backend_connection_errors_total":     {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "Total of Backend connection errors"},
+	"urd_healthy_hosts_count":             {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The number of healthy instances registered with load balance"},
+	"urd_average_elb_latency":             {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "Average latency in seconds from ELB sent the request to a instance until instance starts to respond"},
+	"urd_request_count":                   {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "Total of requests in the last interval (60 seconds by default)"},
+	"urd_spillovercount_total":            {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The total number of requests that were rejected because the surge queue is full."},
+	"urd_surge_queue_length":              {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The total number of requests that are pending routing"},
+	"urd_unhealthy_hosts_count":           {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The number of unhealthy instances registered with load balancer"},
+	"urd_target_response_time":            {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "Average response time in seconds from an ALB target to respond"},
+	"urd_rejected_connection_count_total": {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The number of connections rejected because the ALB had reached its maximum number of connections"},
+	"urd_active_flow_count":               {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The total number of concurrent flows (or connections) from clients to targets behind the NLB"},
+	"urd_new_flow_count_total":            {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The total number of new flows (or connections) established from clients to targets behind the NLB"},
+	"urd_processed_bytes_total":           {[]string{"elb_name", "svc_name", "namespace", "lb_type"}, "The total number of bytes processed by the NLB"},
+	"urd_tcp_reset_count_total":           {[]string{"elb_name", "svc_name", "namespace", "lb_type", "reset_type"}, "The total number of TCP RST packets generated, broken down by origin"},
+	"urd_scrape_duration_seconds":         {nil, "Time taken to complete a full scrape of all load balancers"},
+	"urd_elb_info":                        {[]string{"elb_name", "svc_name", "namespace", "lb_type", "tag_Environment"}, "Always 1; carries the load balancer's allow-listed cloud tags as labels (see --include-tag)"},
+}
+
+// dumpDescs drains a Collector's Describe channel into a map of metric name
+// to its labels and help text.
+func dumpDescs(t *testing.T, c prometheus.Collector) map[string]wantDesc {
+	t.Helper()
+
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	got := make(map[string]wantDesc)
+	for desc := range ch {
+		// *prometheus.Desc has no exported fields; parse its fqName, help
+		// text and label names out of the String() form it documents for
+		// tests: Desc{fqName: "...", help: "...", constLabels: {}, variableLabels: [...]}
+		s := desc.String()
+		name := between(s, `fqName: "`, `"`)
+		help := between(s, `help: "`, `"`)
+		labels := between(s, `variableLabels: [`, `]`)
+		var names []string
+		if labels != "" {
+			for _, l := range strings.Split(labels, " ") {
+				if l != "" {
+					names = append(names, l)
+				}
+			}
+		}
+		got[name] = wantDesc{labels: names, help: help}
+	}
+	return got
+}
+
+func between(s, start, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	j := strings.Index(s, end)
+	if j < 0 {
+		return ""
+	}
+	return s[:j]
+}
+
+func TestELBCollectorDescribe(t *testing.T) {
+	src := aws.New(aws.Options{})
+	c := NewELBCollector(sources.ProviderAWS, src, time.Minute, 30*time.Second, 10, []string{"Environment"})
+	got := dumpDescs(t, c)
+
+	for name, want := range wantDescs {
+		gotDesc, ok := got[name]
+		if !ok {
+			t.Errorf("missing descriptor for metric %q", name)
+			continue
+		}
+		if strings.Join(gotDesc.labels, ",") != strings.Join(want.labels, ",") {
+			t.Errorf("metric %q: got labels %v, want %v", name, gotDesc.labels, want.labels)
+		}
+		if gotDesc.help != want.help {
+			t.Errorf("metric %q: got help %q, want %q", name, gotDesc.help, want.help)
+		}
+	}
+
+	for name := range got {
+		if _, ok := wantDescs[name]; !ok {
+			t.Errorf("unexpected descriptor for metric %q (update wantDescs if this is intentional)", name)
+		}
+	}
+}
@@ -2,228 +2,120 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"regexp"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/ericchiang/k8s"
-	api "github.com/ericchiang/k8s/api/v1"
-	"github.com/ghodss/yaml"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/erickfaustino/urd/sources"
+	"github.com/erickfaustino/urd/sources/aws"
+	"github.com/erickfaustino/urd/sources/azure"
+	"github.com/erickfaustino/urd/sources/gcp"
+)
+
+var (
+	scrapeInterval        time.Duration
+	scrapeTimeout         time.Duration
+	batchSize             int
+	maxConcurrentRequests int
+
+	cloudProvider        string
+	gcpProject           string
+	azureSubscriptionID  string
+	azureNodeResourceGrp string
+	includeTags          stringListFlag
 )
 
-var cwClient *cloudwatch.CloudWatch
-var httpRequestsTotal *prometheus.CounterVec
-var backendConnectionsErrors *prometheus.CounterVec
-var healthyHostCount *prometheus.GaugeVec
-var elbLatency *prometheus.HistogramVec
-var requestCount *prometheus.CounterVec
-var spilloverCount *prometheus.CounterVec
-var surgeQueueLength *prometheus.CounterVec
-var unhealthyHostCount *prometheus.GaugeVec
-
-type elbMetric struct {
-	MetricName string
-	Statistic  string
-	Prometheus func(string, *string, *string, float64)
+func init() {
+	flag.DurationVar(&scrapeInterval, "scrape-interval", time.Minute, "How often to scrape the cloud provider for load balancer metrics")
+	flag.DurationVar(&scrapeTimeout, "scrape-timeout", 30*time.Second, "How long a single refresh may take before its cloud API calls are abandoned")
+	flag.IntVar(&batchSize, "batch-size", aws.MaxQueriesPerCall, "Number of MetricDataQuery entries to pack into a single CloudWatch GetMetricData call (AWS only, max 500)")
+	flag.IntVar(&maxConcurrentRequests, "max-concurrent-requests", 10, "Number of metric API calls to have in flight at once")
+	flag.StringVar(&cloudProvider, "cloud-provider", "auto", `Cloud the cluster runs on: "aws", "gcp", "azure", or "auto" to detect it from a node's providerID`)
+	flag.StringVar(&gcpProject, "gcp-project", "", "GCP project to read Cloud Monitoring metrics from (required when --cloud-provider=gcp)")
+	flag.StringVar(&azureSubscriptionID, "azure-subscription-id", "", "Azure subscription to read Monitor metrics from (required when --cloud-provider=azure)")
+	flag.StringVar(&azureNodeResourceGrp, "azure-node-resource-group", "", "AKS node resource group (the \"MC_*\" one) that owns the cluster's load balancer infrastructure (required when --cloud-provider=azure)")
+	flag.Var(&includeTags, "include-tag", "AWS tag key to copy onto urd_elb_info as a label (AWS only, repeatable)")
 }
 
-// metrics Struct maps all metrics available for AWS Classic ELBs and their respectives useful statistics.
-var metrics = []elbMetric{
-	{"HTTPCode_Backend_2XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("2XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"HTTPCode_Backend_3XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("3XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"HTTPCode_Backend_4XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("4XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"HTTPCode_Backend_5XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("5XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"HTTPCode_ELB_4XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("ELB_4XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"HTTPCode_ELB_5XX", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		httpRequestsTotal.WithLabelValues("ELB_5XX", elbName, *svcName, *ns).Add(value)
-	}},
-	{"BackendConnectionErrors", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		backendConnectionsErrors.WithLabelValues(elbName, *svcName, *ns).Add(value)
-	}},
-	{"HealthyHostCount", "Average", func(elbName string, svcName *string, ns *string, value float64) {
-		healthyHostCount.WithLabelValues(elbName, *svcName, *ns).Set(value)
-	}},
-	{"Latency", "Average", func(elbName string, svcName *string, ns *string, value float64) {
-		elbLatency.WithLabelValues(elbName, *svcName, *ns).Observe(value)
-	}},
-	{"RequestCount", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		requestCount.WithLabelValues(elbName, *svcName, *ns).Add(value)
-	}},
-	{"SpilloverCount", "Sum", func(elbName string, svcName *string, ns *string, value float64) {
-		spilloverCount.WithLabelValues(elbName, *svcName, *ns).Add(value)
-	}},
-	{"SurgeQueueLength", "Maximum", func(elbName string, svcName *string, ns *string, value float64) {
-		surgeQueueLength.WithLabelValues(elbName, *svcName, *ns).Add(value)
-	}},
-	{"UnHealthyHostCount", "Average", func(elbName string, svcName *string, ns *string, value float64) {
-		unhealthyHostCount.WithLabelValues(elbName, *svcName, *ns).Set(value)
-	}},
+// stringListFlag accumulates repeated occurrences of a flag into a slice,
+// e.g. --include-tag=Env --include-tag=Team.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
 }
 
-// Init function create the CloudWatch Client and initializes all Prometheus Counters, Gauge and Histogram to register metrics.
-func init() {
-	sess := session.Must(session.NewSession())
-	cwClient = cloudwatch.New(sess)
-	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_http_requests_total", Help: "Total of HTTP Requests"}, []string{"status", "elb_name", "svc_name", "namespace"})
-	backendConnectionsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "backend_connection_errors_total", Help: "Total of Backend connection errors"}, []string{"elb_name", "svc_name", "namespace"})
-	healthyHostCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_healthy_hosts_count", Help: "The number of healthy instances registered with load balance"}, []string{"elb_name", "svc_name", "namespace"})
-	elbLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "urd_average_elb_latency", Help: "Average latency in seconds from ELB sent the request to a instance until instance starts to respond"}, []string{"elb_name", "svc_name", "namespace"})
-	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_request_count", Help: "Total of requests in the last interval (60 seconds by default)"}, []string{"elb_name", "svc_name", "namespace"})
-	spilloverCount = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_spillovercount_total", Help: "The total number of requests that were rejected because the surge queue is full."}, []string{"elb_name", "svc_name", "namespace"})
-	surgeQueueLength = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_surge_queue_length", Help: "The total number of requests that are pending routing"}, []string{"elb_name", "svc_name", "namespace"})
-	unhealthyHostCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "urd_unhealthy_hosts_count", Help: "The number of unhealthy instances registered with load balancer"}, []string{"elb_name", "svc_name", "namespace"})
-
-	prometheus.MustRegister(httpRequestsTotal, backendConnectionsErrors, healthyHostCount, elbLatency, requestCount, spilloverCount, surgeQueueLength, unhealthyHostCount)
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
-// If
-func loadClient() (*k8s.Client, error) {
-	kubeconfigPath := "/srv/kubernetes/kubeconfig"
-	if kubeCfg := os.Getenv("URD_KUBECONFIG_PATH"); kubeCfg != "" {
-		kubeconfigPath = kubeCfg
-	}
+func main() {
+	flag.Parse()
+	ctx := context.Background()
 
-	data, err := ioutil.ReadFile(kubeconfigPath)
+	provider, err := resolveProvider(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("read kubeconfig: %v", err)
-	}
-
-	// Unmarshal YAML into a Kubernetes config object.
-	var config k8s.Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("unmarshal kubeconfig: %v", err)
+		log.Fatalf("resolve cloud provider: %v", err)
 	}
-	return k8s.NewClient(&config)
-}
 
-func getAllServices() []api.Service {
-	k8sClient, err := loadClient()
+	src, err := newSource(ctx, provider)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("create %s metric source: %v", provider, err)
 	}
 
-	namespaces, err := k8sClient.CoreV1().ListNamespaces(context.Background())
-	services := make([]api.Service, 0)
-	for _, namespace := range namespaces.Items {
-		svc, err := k8sClient.CoreV1().ListServices(context.Background(), *namespace.Metadata.Name)
-		if err != nil {
-			log.Fatal(err)
-		}
+	collector := NewELBCollector(provider, src, scrapeInterval, scrapeTimeout, maxConcurrentRequests, includeTags)
+	go collector.watchServices(ctx)
 
-		for _, service := range svc.Items {
-			if *service.Spec.Type == "LoadBalancer" {
-				services = append(services, *service)
-			}
-		}
+	prometheus.MustRegister(collector)
+	if srcCollector, ok := src.(prometheus.Collector); ok {
+		prometheus.MustRegister(srcCollector)
 	}
 
-	return services
+	http.Handle("/metrics", promhttp.Handler())
+	log.Println("Listening on :8080/metrics")
+	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
-func getElbMetric(elbName string, metricName string, statisticType string) *float64 {
-	currentTime := time.Now()
-	lastMinute := currentTime.Add(-1 * time.Minute)
-	data, err := cwClient.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: []*cloudwatch.Dimension{
-			&cloudwatch.Dimension{
-				Name:  aws.String("LoadBalancerName"),
-				Value: aws.String(elbName),
-			},
-		},
-		StartTime:  aws.Time(lastMinute),
-		EndTime:    aws.Time(currentTime),
-		MetricName: aws.String(metricName),
-		Namespace:  aws.String("AWS/ELB"),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String(statisticType)},
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	if len(data.Datapoints) == 0 {
-		r := float64(0)
-		return &r
+// resolveProvider returns the configured --cloud-provider, or detects it
+// from the cluster's nodes when it's left at "auto".
+func resolveProvider(ctx context.Context) (sources.Provider, error) {
+	if cloudProvider != "auto" {
+		return sources.Provider(cloudProvider), nil
 	}
-	var value *float64
-	dp := data.Datapoints[0]
-	switch statisticType {
-	case "Sum":
-		value = dp.Sum
-	case "Average":
-		value = dp.Average
-	case "Maximum":
-		value = dp.Maximum
-	case "Minimum":
-		value = dp.Minimum
-	}
-	return value
-}
 
-// This function returns the real ELB name from ELB DNS.
-// internal-a8280213c611d114o7340onc0d34252-152337689.us-east-1.elb.amazonaws.com -> a8280213c611d114o7340onc0d34252
-func elbNameFromElbDNS(elbDNS string) string {
-	re, err := regexp.Compile("(.*)(?:-[0-9]{6})")
+	k8sClient, err := loadClient()
 	if err != nil {
-		fmt.Println(err)
+		return "", err
 	}
-	elbName := re.FindStringSubmatch(elbDNS)[1]
-	return strings.TrimPrefix(elbName, "internal-")
+	return detectProvider(ctx, k8sClient)
 }
 
-func collectMetrics() {
-	services := getAllServices()
-
-	var wg sync.WaitGroup
-	wg.Add(len(services) * len(metrics))
-
-	getMetric := func(m elbMetric, s api.Service) {
-		elbName := elbNameFromElbDNS(*s.Status.LoadBalancer.Ingress[0].Hostname)
-		m.Prometheus(elbName, s.Metadata.Name, s.Metadata.Namespace, *getElbMetric(elbName, m.MetricName, m.Statistic))
-		wg.Done()
-	}
-
-	for _, service := range services {
-		for _, metric := range metrics {
-			go getMetric(metric, service)
+// newSource constructs the sources.MetricSource for the selected provider.
+func newSource(ctx context.Context, provider sources.Provider) (sources.MetricSource, error) {
+	switch provider {
+	case sources.ProviderAWS:
+		return aws.New(aws.Options{BatchSize: batchSize, MaxConcurrentRequests: maxConcurrentRequests, IncludeTags: includeTags}), nil
+	case sources.ProviderGCP:
+		if gcpProject == "" {
+			return nil, fmt.Errorf("--gcp-project is required on GCP")
 		}
-	}
-
-	wg.Wait()
-}
-
-func main() {
-	http.Handle("/metrics", promhttp.Handler())
-	go http.ListenAndServe(":8080", nil)
-	log.Println("Listening on :8080/metrics")
-
-	for {
-		log.Println("Begun to get CW data for all ELBs")
-		begin := time.Now()
-		collectMetrics()
-		log.Println("All metrics collected.")
-		timediff := time.Now().Sub(begin)
-		log.Printf("Sleeping for %s", time.Minute-timediff)
-		time.Sleep(time.Minute - timediff)
+		return gcp.New(ctx, gcpProject)
+	case sources.ProviderAzure:
+		if azureSubscriptionID == "" {
+			return nil, fmt.Errorf("--azure-subscription-id is required on Azure")
+		}
+		if azureNodeResourceGrp == "" {
+			return nil, fmt.Errorf("--azure-node-resource-group is required on Azure")
+		}
+		return azure.New(azureSubscriptionID, azureNodeResourceGrp)
+	default:
+		return nil, fmt.Errorf("unsupported cloud provider %q", provider)
 	}
 }
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	api "github.com/ericchiang/k8s/api/v1"
+	"github.com/ghodss/yaml"
+
+	"github.com/erickfaustino/urd/sources"
+	"github.com/erickfaustino/urd/sources/aws"
+	"github.com/erickfaustino/urd/sources/azure"
+	"github.com/erickfaustino/urd/sources/gcp"
+)
+
+// If
+func loadClient() (*k8s.Client, error) {
+	kubeconfigPath := "/srv/kubernetes/kubeconfig"
+	if kubeCfg := os.Getenv("URD_KUBECONFIG_PATH"); kubeCfg != "" {
+		kubeconfigPath = kubeCfg
+	}
+
+	data, err := ioutil.ReadFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("read kubeconfig: %v", err)
+	}
+
+	// Unmarshal YAML into a Kubernetes config object.
+	var config k8s.Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal kubeconfig: %v", err)
+	}
+	return k8s.NewClient(&config)
+}
+
+// detectProvider inspects the providerID of a node in the cluster to figure
+// out which cloud urd is running on, so main can pick the matching
+// sources.MetricSource without an operator having to say so explicitly.
+func detectProvider(ctx context.Context, k8sClient *k8s.Client) (sources.Provider, error) {
+	nodes, err := k8sClient.CoreV1().ListNodes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list nodes: %v", err)
+	}
+	if len(nodes.Items) == 0 {
+		return "", fmt.Errorf("no nodes found")
+	}
+
+	var providerID string
+	if spec := nodes.Items[0].Spec; spec != nil && spec.ProviderID != nil {
+		providerID = *spec.ProviderID
+	}
+
+	switch {
+	case strings.HasPrefix(providerID, "aws://"):
+		return sources.ProviderAWS, nil
+	case strings.HasPrefix(providerID, "gce://"):
+		return sources.ProviderGCP, nil
+	case strings.HasPrefix(providerID, "azure://"):
+		return sources.ProviderAzure, nil
+	default:
+		return "", fmt.Errorf("unrecognized node providerID %q", providerID)
+	}
+}
+
+// serviceRecord is the cached state urd keeps for a single LoadBalancer
+// Service: its resolved sources.LoadBalancer and the canonical metric keys
+// that apply to it, so a DELETED watch event can prune exactly the label
+// combinations that were published for it.
+type serviceRecord struct {
+	LB      sources.LoadBalancer
+	Metrics []sources.MetricKey
+}
+
+// serviceKey returns the map key urd tracks a Service's LoadBalancer state
+// under: its namespace/name, which is stable across ADDED/MODIFIED/DELETED
+// events for the same object.
+func serviceKey(svc api.Service) string {
+	return *svc.Metadata.Namespace + "/" + *svc.Metadata.Name
+}
+
+// watchServices keeps the collector's live-service index in sync with the
+// apiserver via a long-lived watch across all namespaces, reconnecting after
+// a fixed 5-second delay if the stream breaks. It blocks until ctx is done.
+func (c *ELBCollector) watchServices(ctx context.Context) {
+	for {
+		if err := c.runServiceWatch(ctx); err != nil {
+			log.Printf("service watch failed, reconnecting: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *ELBCollector) runServiceWatch(ctx context.Context) error {
+	k8sClient, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	c.syncResolver(ctx)
+
+	resourceVersion, err := c.listServices(ctx, k8sClient)
+	if err != nil {
+		return fmt.Errorf("list services: %v", err)
+	}
+
+	watcher, err := k8sClient.CoreV1().WatchServices(ctx, k8s.AllNamespaces, k8s.QueryParam("resourceVersion", resourceVersion))
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for {
+		event, svc, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+
+		if event.Type != nil && *event.Type == k8s.EventDeleted {
+			c.removeService(serviceKey(*svc))
+			continue
+		}
+
+		c.processService(*svc)
+	}
+}
+
+// listServices seeds c.services from a full ListServices call before the
+// watch begins, mirroring the list-then-watch pattern client-go's reflector
+// always follows. A naked WatchServices would otherwise leave c.services
+// empty until each already-existing LoadBalancer Service happened to
+// receive a new ADDED/MODIFIED event - which may never happen for
+// long-lived, stable ELBs - on every urd startup and on every watch
+// reconnect. It returns the list's resourceVersion, so the subsequent
+// watch resumes from exactly that point instead of re-racing the list.
+func (c *ELBCollector) listServices(ctx context.Context, k8sClient *k8s.Client) (string, error) {
+	list, err := k8sClient.CoreV1().ListServices(ctx, k8s.AllNamespaces)
+	if err != nil {
+		return "", err
+	}
+
+	for _, svc := range list.Items {
+		c.processService(*svc)
+	}
+
+	if list.Metadata == nil || list.Metadata.ResourceVersion == nil {
+		return "", nil
+	}
+	return *list.Metadata.ResourceVersion, nil
+}
+
+// processService resolves svc's load balancer, if it has one yet, and
+// upserts or removes it from c.services accordingly. Both the initial
+// ListServices seed and the ongoing watch loop funnel through this so a
+// Service is handled identically regardless of which one produced it.
+func (c *ELBCollector) processService(svc api.Service) {
+	key := serviceKey(svc)
+
+	if svc.Spec.Type == nil || *svc.Spec.Type != "LoadBalancer" {
+		// Not (or no longer) a LoadBalancer Service; drop any metrics
+		// that might be tracked for it, e.g. it was just edited down
+		// to ClusterIP.
+		c.removeService(key)
+		return
+	}
+
+	lb, ok := c.resolveLoadBalancer(svc)
+	if !ok {
+		// Still provisioning: no load balancer endpoint assigned yet.
+		return
+	}
+
+	c.upsertService(key, lb)
+}
+
+// syncResolver refreshes whichever provider's IP/hostname-to-resource
+// cache c.src owns, so resolveLoadBalancer has fresh data for this watch
+// connection. Providers without a resolver (none yet) are a no-op.
+func (c *ELBCollector) syncResolver(ctx context.Context) {
+	switch src := c.src.(type) {
+	case *aws.Source:
+		if err := src.Resolver.Sync(ctx); err != nil {
+			log.Printf("ELB resolver sync: %v", err)
+		}
+	case *gcp.Source:
+		if err := src.Resolver.Sync(ctx); err != nil {
+			log.Printf("GCP load balancer resolver sync: %v", err)
+		}
+	case *azure.Source:
+		if err := src.Resolver.Sync(ctx); err != nil {
+			log.Printf("Azure load balancer resolver sync: %v", err)
+		}
+	}
+}
+
+// resolveLoadBalancer builds the cloud-agnostic sources.LoadBalancer view
+// of a Service, provider-specific resolution and all. It reports false if
+// the Service's load balancer isn't provisioned yet.
+func (c *ELBCollector) resolveLoadBalancer(svc api.Service) (sources.LoadBalancer, bool) {
+	ingress := svc.Status.LoadBalancer.Ingress
+	if len(ingress) == 0 {
+		return sources.LoadBalancer{}, false
+	}
+
+	var annotations map[string]string
+	if svc.Metadata != nil {
+		annotations = svc.Metadata.Annotations
+	}
+
+	lb := sources.LoadBalancer{
+		Provider:    c.provider,
+		SvcName:     *svc.Metadata.Name,
+		Namespace:   *svc.Metadata.Namespace,
+		Annotations: annotations,
+	}
+
+	switch c.provider {
+	case sources.ProviderAWS:
+		if ingress[0].Hostname == nil {
+			return sources.LoadBalancer{}, false
+		}
+		c.resolveAWSLoadBalancer(&lb, annotations, *ingress[0].Hostname)
+	case sources.ProviderGCP:
+		if ingress[0].Ip == nil {
+			return sources.LoadBalancer{}, false
+		}
+		name, ok := c.resolveGCPLoadBalancer(*ingress[0].Ip)
+		if !ok {
+			// Can't address this load balancer in Cloud Monitoring without
+			// its url_map_name/backend-service name; wait for the next
+			// resolver Sync to pick it up rather than query with a
+			// meaningless IP.
+			return sources.LoadBalancer{}, false
+		}
+		lb.Type = gcp.DetectType(annotations)
+		lb.Name = name
+	case sources.ProviderAzure:
+		if ingress[0].Ip == nil {
+			return sources.LoadBalancer{}, false
+		}
+		resourceID, ok := c.resolveAzureLoadBalancer(*ingress[0].Ip)
+		if !ok {
+			// Can't address this load balancer in Azure Monitor without its
+			// resource ID; wait for the next resolver Sync.
+			return sources.LoadBalancer{}, false
+		}
+		lb.Type = azure.DetectType(annotations)
+		lb.Name = resourceID
+	default:
+		return sources.LoadBalancer{}, false
+	}
+
+	return lb, true
+}
+
+// resolveAWSLoadBalancer fills in lb's Name, Type and Tags for an AWS
+// Service. It prefers the Resolver's DescribeLoadBalancers-based cache,
+// which identifies the ELB precisely and carries its tags; it falls back
+// to parsing the hostname with NameFromDNS/DetectType when the resolver
+// hasn't seen this DNS name, e.g. because Sync hasn't run yet or failed.
+func (c *ELBCollector) resolveAWSLoadBalancer(lb *sources.LoadBalancer, annotations map[string]string, hostname string) {
+	if awsSrc, ok := c.src.(*aws.Source); ok {
+		if resolved, ok := awsSrc.Resolver.Resolve(hostname); ok {
+			lb.Name = resolved.Name
+			lb.Type = resolved.Type
+			lb.Tags = resolved.Tags
+			return
+		}
+	}
+
+	lb.Type = aws.DetectType(annotations, &hostname)
+	lb.Name = aws.NameFromDNS(hostname)
+}
+
+// resolveGCPLoadBalancer returns the url_map_name/backend-service name
+// gcp.Source's Resolver has for ip, so gcp.Source.Fetch has something real
+// to filter Cloud Monitoring on instead of the IP itself.
+func (c *ELBCollector) resolveGCPLoadBalancer(ip string) (string, bool) {
+	gcpSrc, ok := c.src.(*gcp.Source)
+	if !ok {
+		return "", false
+	}
+	return gcpSrc.Resolver.Resolve(ip)
+}
+
+// resolveAzureLoadBalancer returns the Azure resource ID azure.Source's
+// Resolver has for ip, so azure.Source.Fetch has something real to query
+// Azure Monitor with instead of the IP itself.
+func (c *ELBCollector) resolveAzureLoadBalancer(ip string) (string, bool) {
+	azureSrc, ok := c.src.(*azure.Source)
+	if !ok {
+		return "", false
+	}
+	return azureSrc.Resolver.Resolve(ip)
+}
+
+// upsertService records a Service's resolved LoadBalancer as live,
+// replacing whatever was previously stored under the same key.
+func (c *ELBCollector) upsertService(key string, lb sources.LoadBalancer) {
+	record := serviceRecord{
+		LB:      lb,
+		Metrics: c.metricsFor(lb.Type),
+	}
+
+	c.servicesMu.Lock()
+	if c.services == nil {
+		c.services = make(map[string]serviceRecord)
+	}
+	c.services[key] = record
+	c.servicesMu.Unlock()
+
+	c.publishELBInfo(lb)
+}
+
+// removeService drops a Service from the live index and prunes the
+// Prometheus label combinations it previously published, so stale time
+// series don't accumulate forever.
+func (c *ELBCollector) removeService(key string) {
+	c.servicesMu.Lock()
+	record, ok := c.services[key]
+	if ok {
+		delete(c.services, key)
+	}
+	c.servicesMu.Unlock()
+
+	if ok {
+		c.pruneLabels(record)
+	}
+}
+
+// liveServices returns a snapshot of the Services currently known to have a
+// LoadBalancer backing them.
+func (c *ELBCollector) liveServices() []serviceRecord {
+	c.servicesMu.RLock()
+	defer c.servicesMu.RUnlock()
+
+	records := make([]serviceRecord, 0, len(c.services))
+	for _, record := range c.services {
+		records = append(records, record)
+	}
+	return records
+}
+
+// pruneLabels deletes every label combination a Service's metric set could
+// have produced, across all of the vectors those metrics feed.
+func (c *ELBCollector) pruneLabels(r serviceRecord) {
+	for _, metric := range r.Metrics {
+		if def, ok := canonicalMetrics[metric]; ok {
+			def.prune(c, r.LB)
+		}
+	}
+	c.pruneELBInfo(r.LB)
+}
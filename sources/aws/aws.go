@@ -0,0 +1,362 @@
+// Package aws implements sources.MetricSource against CloudWatch for
+// Classic ELBs, ALBs and NLBs.
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/erickfaustino/urd/sources"
+)
+
+// LBTypeAnnotation is set by the in-tree AWS cloud provider / AWS Load
+// Balancer Controller to request a non-Classic load balancer.
+const LBTypeAnnotation = "service.beta.kubernetes.io/aws-load-balancer-type"
+
+// The sources.LoadBalancer.Type values this package understands.
+const (
+	TypeClassic = "classic"
+	TypeALB     = "alb"
+	TypeNLB     = "nlb"
+)
+
+// MaxQueriesPerCall is the maximum number of MetricDataQuery entries
+// CloudWatch accepts in a single GetMetricData call.
+const MaxQueriesPerCall = 500
+
+// classicDNSRe matches the hostname shape used by Classic ELBs: a hyphen
+// followed by a run of (at least six) decimal digits.
+// internal-a8280213c611d114o7340onc0d34252-152337689.us-east-1.elb.amazonaws.com -> a8280213c611d114o7340onc0d34252
+var classicDNSRe = regexp.MustCompile(`(.*)(?:-[0-9]{6})`)
+
+// DetectType figures out whether a Service's load balancer is a Classic
+// ELB, an ALB or an NLB. It first looks at the annotation set by the AWS
+// cloud provider / AWS Load Balancer Controller, then falls back to the
+// shape of the ingress hostname.
+func DetectType(annotations map[string]string, hostname *string) string {
+	switch strings.ToLower(annotations[LBTypeAnnotation]) {
+	case "nlb":
+		return TypeNLB
+	case "alb", "external":
+		return TypeALB
+	}
+
+	if hostname == nil {
+		return TypeClassic
+	}
+
+	// Classic ELB hostnames end in a six-digit suffix; ALBs and NLBs
+	// don't follow that shape, so anything that doesn't match is assumed
+	// to be an ALB until the NLB annotation says otherwise.
+	if !classicDNSRe.MatchString(*hostname) {
+		return TypeALB
+	}
+	return TypeClassic
+}
+
+// NameFromDNS returns the real ELB name from its DNS hostname.
+// internal-a8280213c611d114o7340onc0d34252-152337689.us-east-1.elb.amazonaws.com -> a8280213c611d114o7340onc0d34252
+// ALB/NLB hostnames don't carry that numeric suffix, so when classicDNSRe
+// doesn't match, NameFromDNS falls back to the hostname's first DNS label.
+// That fallback is only good enough for display: CloudWatch's ALB/NLB
+// LoadBalancer dimension needs the arnSuffix shape (app/name/id), which
+// isn't derivable from the hostname alone. Fetch only returns real data
+// for ALB/NLB once the Resolver has synced and supplied that shape.
+func NameFromDNS(hostname string) string {
+	if m := classicDNSRe.FindStringSubmatch(hostname); m != nil {
+		return strings.TrimPrefix(m[1], "internal-")
+	}
+	if i := strings.IndexByte(hostname, '.'); i >= 0 {
+		return hostname[:i]
+	}
+	return hostname
+}
+
+type metricDef struct {
+	Name      string
+	Statistic string
+}
+
+// metricTable maps (LoadBalancer.Type, canonical metric key) to the
+// CloudWatch metric name and statistic that backs it.
+var metricTable = map[string]map[sources.MetricKey]metricDef{
+	TypeClassic: {
+		sources.MetricHTTP2xx:            {"HTTPCode_Backend_2XX", "Sum"},
+		sources.MetricHTTP3xx:            {"HTTPCode_Backend_3XX", "Sum"},
+		sources.MetricHTTP4xx:            {"HTTPCode_Backend_4XX", "Sum"},
+		sources.MetricHTTP5xx:            {"HTTPCode_Backend_5XX", "Sum"},
+		sources.MetricELB4xx:             {"HTTPCode_ELB_4XX", "Sum"},
+		sources.MetricELB5xx:             {"HTTPCode_ELB_5XX", "Sum"},
+		sources.MetricBackendErrors:      {"BackendConnectionErrors", "Sum"},
+		sources.MetricHealthyHostCount:   {"HealthyHostCount", "Average"},
+		sources.MetricLatency:            {"Latency", "Average"},
+		sources.MetricRequestCount:       {"RequestCount", "Sum"},
+		sources.MetricSpilloverCount:     {"SpilloverCount", "Sum"},
+		sources.MetricSurgeQueueLength:   {"SurgeQueueLength", "Maximum"},
+		sources.MetricUnhealthyHostCount: {"UnHealthyHostCount", "Average"},
+	},
+	TypeALB: {
+		sources.MetricRequestCount:        {"RequestCount", "Sum"},
+		sources.MetricHTTP2xx:             {"HTTPCode_Target_2XX_Count", "Sum"},
+		sources.MetricHTTP3xx:             {"HTTPCode_Target_3XX_Count", "Sum"},
+		sources.MetricHTTP4xx:             {"HTTPCode_Target_4XX_Count", "Sum"},
+		sources.MetricHTTP5xx:             {"HTTPCode_Target_5XX_Count", "Sum"},
+		sources.MetricLatency:             {"TargetResponseTime", "Average"},
+		sources.MetricHealthyHostCount:    {"HealthyHostCount", "Average"},
+		sources.MetricUnhealthyHostCount:  {"UnHealthyHostCount", "Average"},
+		sources.MetricRejectedConnections: {"RejectedConnectionCount", "Sum"},
+	},
+	TypeNLB: {
+		sources.MetricActiveFlowCount:    {"ActiveFlowCount", "Average"},
+		sources.MetricNewFlowCount:       {"NewFlowCount", "Sum"},
+		sources.MetricProcessedBytes:     {"ProcessedBytes", "Sum"},
+		sources.MetricTCPResetClient:     {"TCP_Client_Reset_Count", "Sum"},
+		sources.MetricTCPResetELB:        {"TCP_ELB_Reset_Count", "Sum"},
+		sources.MetricTCPResetTarget:     {"TCP_Target_Reset_Count", "Sum"},
+		sources.MetricUnhealthyHostCount: {"UnHealthyHostCount", "Average"},
+	},
+}
+
+func namespaceFor(t string) string {
+	switch t {
+	case TypeALB:
+		return "AWS/ApplicationELB"
+	case TypeNLB:
+		return "AWS/NetworkELB"
+	default:
+		return "AWS/ELB"
+	}
+}
+
+func dimensionFor(t string) string {
+	if t == TypeClassic {
+		return "LoadBalancerName"
+	}
+	return "LoadBalancer"
+}
+
+// arnSuffix extracts the value CloudWatch's ALB/NLB "LoadBalancer"
+// dimension expects from a load balancer's ARN: the
+// arn:...:loadbalancer/ prefix stripped off, leaving e.g.
+// app/my-alb/50dc6c495c0c9188 (net/my-nlb/50dc6c495c0c9188 for NLBs). The
+// plain load balancer name CloudWatch accepts for Classic ELBs is not
+// accepted here; ALB/NLB queries dimensioned on anything else come back
+// empty.
+func arnSuffix(arn string) string {
+	const marker = "loadbalancer/"
+	if i := strings.Index(arn, marker); i >= 0 {
+		return arn[i+len(marker):]
+	}
+	return arn
+}
+
+// Options configures how a Source batches CloudWatch calls and resolves
+// ELBs.
+type Options struct {
+	// BatchSize is the number of MetricDataQuery entries packed into a
+	// single GetMetricData call, capped at CloudWatch's own limit of 500.
+	BatchSize int
+	// MaxConcurrentRequests bounds how many GetMetricData calls are in
+	// flight at once.
+	MaxConcurrentRequests int
+	// IncludeTags is the allow-list of AWS tag keys the Resolver copies
+	// onto a ResolvedLB, e.g. "kubernetes.io/service-name", "Environment".
+	IncludeTags []string
+}
+
+// Source is a sources.MetricSource, sources.BatchFetcher and
+// prometheus.Collector backed by CloudWatch. It also owns the Resolver
+// that identifies Classic ELBs/ALBs/NLBs by DescribeLoadBalancers instead
+// of parsing their DNS name.
+type Source struct {
+	cw       *cloudwatch.CloudWatch
+	Resolver *Resolver
+	opts     Options
+
+	apiCallsTotal  prometheus.Counter
+	apiErrorsTotal *prometheus.CounterVec
+}
+
+// New creates a CloudWatch-backed Source.
+func New(opts Options) *Source {
+	if opts.BatchSize <= 0 || opts.BatchSize > MaxQueriesPerCall {
+		opts.BatchSize = MaxQueriesPerCall
+	}
+	if opts.MaxConcurrentRequests <= 0 {
+		opts.MaxConcurrentRequests = 10
+	}
+
+	sess := session.Must(session.NewSession())
+	return &Source{
+		cw:             cloudwatch.New(sess),
+		Resolver:       NewResolver(opts.IncludeTags),
+		opts:           opts,
+		apiCallsTotal:  prometheus.NewCounter(prometheus.CounterOpts{Name: "urd_cloudwatch_api_calls_total", Help: "Total number of CloudWatch GetMetricData calls made"}),
+		apiErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "urd_cloudwatch_api_errors_total", Help: "Total number of CloudWatch GetMetricData calls that returned an error, by error code"}, []string{"code"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (s *Source) Describe(ch chan<- *prometheus.Desc) {
+	s.apiCallsTotal.Describe(ch)
+	s.apiErrorsTotal.Describe(ch)
+	s.Resolver.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *Source) Collect(ch chan<- prometheus.Metric) {
+	s.apiCallsTotal.Collect(ch)
+	s.apiErrorsTotal.Collect(ch)
+	s.Resolver.Collect(ch)
+}
+
+// Supports implements sources.MetricSource.
+func (s *Source) Supports(lb sources.LoadBalancer) bool {
+	return lb.Provider == sources.ProviderAWS
+}
+
+// Metrics implements sources.MetricLister.
+func (s *Source) Metrics(lbType string) []sources.MetricKey {
+	keys := make([]sources.MetricKey, 0, len(metricTable[lbType]))
+	for k := range metricTable[lbType] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Fetch implements sources.MetricSource.
+func (s *Source) Fetch(ctx context.Context, lb sources.LoadBalancer, metric sources.MetricKey, start, end time.Time) (float64, error) {
+	values, err := s.FetchBatch(ctx, []sources.Query{{ID: "q0", LB: lb, Metric: metric}}, start, end)
+	if err != nil {
+		return 0, err
+	}
+	return values["q0"], nil
+}
+
+// FetchBatch implements sources.BatchFetcher. It groups queries by
+// LoadBalancer type (CloudWatch namespace/dimension are fixed per call),
+// splits each group into chunks of at most opts.BatchSize, and issues the
+// resulting GetMetricData calls concurrently, bounded by
+// opts.MaxConcurrentRequests.
+func (s *Source) FetchBatch(ctx context.Context, queries []sources.Query, start, end time.Time) (map[string]float64, error) {
+	batches := batchQueries(queries, s.opts.BatchSize)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, s.opts.MaxConcurrentRequests)
+		results = make(map[string]float64)
+	)
+	wg.Add(len(batches))
+	for _, batch := range batches {
+		sem <- struct{}{}
+		go func(batch []sources.Query) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			values, err := s.fetchOne(ctx, batch, start, end)
+			if err != nil {
+				log.Printf("GetMetricData: %v", err)
+				return
+			}
+			mu.Lock()
+			for id, v := range values {
+				results[id] = v
+			}
+			mu.Unlock()
+		}(batch)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// batchQueries groups queries by LoadBalancer type, since CloudWatch's
+// namespace and dimension name are fixed per GetMetricData call, then splits
+// each group into chunks of at most batchSize. Queries whose (type, metric)
+// pair isn't in metricTable are dropped; the caller has nothing to ask
+// CloudWatch for.
+func batchQueries(queries []sources.Query, batchSize int) [][]sources.Query {
+	byType := make(map[string][]sources.Query)
+	for _, q := range queries {
+		if _, ok := metricTable[q.LB.Type][q.Metric]; !ok {
+			continue
+		}
+		byType[q.LB.Type] = append(byType[q.LB.Type], q)
+	}
+
+	var batches [][]sources.Query
+	for _, qs := range byType {
+		for len(qs) > 0 {
+			n := batchSize
+			if n > len(qs) {
+				n = len(qs)
+			}
+			batches = append(batches, qs[:n])
+			qs = qs[n:]
+		}
+	}
+	return batches
+}
+
+// fetchOne issues a single GetMetricData call for a batch of queries that
+// all share a LoadBalancer type.
+func (s *Source) fetchOne(ctx context.Context, batch []sources.Query, start, end time.Time) (map[string]float64, error) {
+	input := &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(start),
+		EndTime:   aws.Time(end),
+	}
+	for _, q := range batch {
+		def := metricTable[q.LB.Type][q.Metric]
+		input.MetricDataQueries = append(input.MetricDataQueries, &cloudwatch.MetricDataQuery{
+			Id: aws.String(q.ID),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(namespaceFor(q.LB.Type)),
+					MetricName: aws.String(def.Name),
+					Dimensions: []*cloudwatch.Dimension{
+						{Name: aws.String(dimensionFor(q.LB.Type)), Value: aws.String(q.LB.Name)},
+					},
+				},
+				Period: aws.Int64(60),
+				Stat:   aws.String(def.Statistic),
+			},
+		})
+	}
+
+	s.apiCallsTotal.Inc()
+	output, err := s.cw.GetMetricDataWithContext(ctx, input)
+	if err != nil {
+		s.apiErrorsTotal.WithLabelValues(errorCode(err)).Inc()
+		return nil, fmt.Errorf("GetMetricData: %v", err)
+	}
+
+	values := make(map[string]float64, len(output.MetricDataResults))
+	for _, result := range output.MetricDataResults {
+		if len(result.Values) == 0 {
+			continue
+		}
+		values[aws.StringValue(result.Id)] = aws.Float64Value(result.Values[0])
+	}
+	return values, nil
+}
+
+// errorCode extracts an AWS error code for the urd_cloudwatch_api_errors_total label without
+// requiring callers to import aws/awserr themselves.
+func errorCode(err error) string {
+	type coder interface{ Code() string }
+	if c, ok := err.(coder); ok {
+		return c.Code()
+	}
+	return "unknown"
+}
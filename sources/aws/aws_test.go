@@ -0,0 +1,96 @@
+package aws
+
+import "testing"
+
+func TestDetectTypeAndNameFromDNS(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		hostname    string
+		wantType    string
+		wantName    string
+	}{
+		{
+			name:     "classic",
+			hostname: "internal-a8280213c611d114o7340onc0d34252-152337689.us-east-1.elb.amazonaws.com",
+			wantType: TypeClassic,
+			wantName: "a8280213c611d114o7340onc0d34252",
+		},
+		{
+			name:     "classic, not internal",
+			hostname: "a8280213c611d114o7340onc0d34252-152337689.us-east-1.elb.amazonaws.com",
+			wantType: TypeClassic,
+			wantName: "a8280213c611d114o7340onc0d34252",
+		},
+		{
+			name:     "alb, inferred from hostname shape",
+			hostname: "k8s-default-myalb-9f8e7d6c5b4a3210.us-east-1.elb.amazonaws.com",
+			wantType: TypeALB,
+			wantName: "k8s-default-myalb-9f8e7d6c5b4a3210",
+		},
+		{
+			name:        "nlb, from annotation",
+			annotations: map[string]string{LBTypeAnnotation: "nlb"},
+			hostname:    "k8s-default-mynlb-9f8e7d6c5b4a3210.us-east-1.elb.amazonaws.com",
+			wantType:    TypeNLB,
+			wantName:    "k8s-default-mynlb-9f8e7d6c5b4a3210",
+		},
+		{
+			name:        "alb, from external annotation",
+			annotations: map[string]string{LBTypeAnnotation: "external"},
+			hostname:    "k8s-default-myalb-9f8e7d6c5b4a3210.us-east-1.elb.amazonaws.com",
+			wantType:    TypeALB,
+			wantName:    "k8s-default-myalb-9f8e7d6c5b4a3210",
+		},
+		{
+			name:     "no hostname",
+			wantType: TypeClassic,
+			wantName: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hostnamePtr *string
+			if tt.hostname != "" {
+				hostnamePtr = &tt.hostname
+			}
+
+			if got := DetectType(tt.annotations, hostnamePtr); got != tt.wantType {
+				t.Errorf("DetectType() = %q, want %q", got, tt.wantType)
+			}
+			if tt.hostname == "" {
+				return
+			}
+			if got := NameFromDNS(tt.hostname); got != tt.wantName {
+				t.Errorf("NameFromDNS(%q) = %q, want %q", tt.hostname, got, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestARNSuffix(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{
+			arn:  "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188",
+			want: "app/my-alb/50dc6c495c0c9188",
+		},
+		{
+			arn:  "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/my-nlb/50dc6c495c0c9188",
+			want: "net/my-nlb/50dc6c495c0c9188",
+		},
+		{
+			arn:  "not-an-arn",
+			want: "not-an-arn",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := arnSuffix(tt.arn); got != tt.want {
+			t.Errorf("arnSuffix(%q) = %q, want %q", tt.arn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/erickfaustino/urd/sources"
+)
+
+func TestBatchQueries(t *testing.T) {
+	makeQueries := func(n int, lbType string, metric sources.MetricKey) []sources.Query {
+		queries := make([]sources.Query, n)
+		for i := range queries {
+			queries[i] = sources.Query{LB: sources.LoadBalancer{Type: lbType}, Metric: metric}
+		}
+		return queries
+	}
+
+	t.Run("splits a single type into batchSize chunks", func(t *testing.T) {
+		queries := makeQueries(5, TypeClassic, sources.MetricRequestCount)
+		batches := batchQueries(queries, 2)
+		if len(batches) != 3 {
+			t.Fatalf("got %d batches, want 3", len(batches))
+		}
+		if len(batches[0]) != 2 || len(batches[1]) != 2 || len(batches[2]) != 1 {
+			t.Errorf("got batch sizes %d/%d/%d, want 2/2/1", len(batches[0]), len(batches[1]), len(batches[2]))
+		}
+	})
+
+	t.Run("never mixes LoadBalancer types in one batch", func(t *testing.T) {
+		queries := append(
+			makeQueries(3, TypeClassic, sources.MetricRequestCount),
+			makeQueries(3, TypeALB, sources.MetricRequestCount)...,
+		)
+		batches := batchQueries(queries, 10)
+		if len(batches) != 2 {
+			t.Fatalf("got %d batches, want 2", len(batches))
+		}
+		for _, batch := range batches {
+			t0 := batch[0].LB.Type
+			for _, q := range batch {
+				if q.LB.Type != t0 {
+					t.Errorf("batch mixes types %q and %q", t0, q.LB.Type)
+				}
+			}
+		}
+	})
+
+	t.Run("drops queries for a metric the LB type doesn't support", func(t *testing.T) {
+		queries := makeQueries(2, TypeALB, sources.MetricActiveFlowCount) // NLB-only metric
+		batches := batchQueries(queries, 10)
+		if len(batches) != 0 {
+			t.Errorf("got %d batches, want 0", len(batches))
+		}
+	})
+}
+
+func TestNewDefaultsBatchSizeAndConcurrency(t *testing.T) {
+	src := New(Options{})
+	if src.opts.BatchSize != MaxQueriesPerCall {
+		t.Errorf("BatchSize = %d, want default %d", src.opts.BatchSize, MaxQueriesPerCall)
+	}
+	if src.opts.MaxConcurrentRequests != 10 {
+		t.Errorf("MaxConcurrentRequests = %d, want default 10", src.opts.MaxConcurrentRequests)
+	}
+
+	src = New(Options{BatchSize: MaxQueriesPerCall + 1, MaxConcurrentRequests: -1})
+	if src.opts.BatchSize != MaxQueriesPerCall {
+		t.Errorf("BatchSize = %d, want clamped to %d", src.opts.BatchSize, MaxQueriesPerCall)
+	}
+	if src.opts.MaxConcurrentRequests != 10 {
+		t.Errorf("MaxConcurrentRequests = %d, want default 10", src.opts.MaxConcurrentRequests)
+	}
+}
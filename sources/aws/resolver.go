@@ -0,0 +1,281 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ResolvedLB is what the Resolver knows about an ELB once it's described
+// it: the value to dimension CloudWatch queries on (the plain name for
+// Classic ELBs, the arnSuffix-shaped app/name/id or net/name/id for
+// ALBs/NLBs), its type, its ARN (v2 only; Classic ELBs aren't
+// ARN-addressable), and whichever of its AWS tags match the resolver's
+// include-tag allow-list.
+type ResolvedLB struct {
+	Name string
+	ARN  string
+	Type string
+	Tags map[string]string
+}
+
+// Resolver identifies Classic ELBs, ALBs and NLBs by calling
+// DescribeLoadBalancers rather than parsing their DNS name, so it isn't
+// fooled by two load balancers sharing a hostname prefix. It caches the
+// result keyed by DNS name and only re-describes on Sync, which callers
+// should invoke once per Service informer resync.
+type Resolver struct {
+	elbClient   *elb.ELB
+	elbv2Client *elbv2.ELBV2
+	includeTags []string
+
+	mu    sync.RWMutex
+	byDNS map[string]ResolvedLB
+
+	resolutionErrorsTotal *prometheus.CounterVec
+}
+
+// NewResolver creates a Resolver. includeTags is the allow-list of AWS tag
+// keys that get copied onto ResolvedLB.Tags; tags outside this list are
+// ignored so an operator doesn't accidentally leak arbitrary account
+// metadata into Prometheus labels.
+func NewResolver(includeTags []string) *Resolver {
+	sess := session.Must(session.NewSession())
+	return &Resolver{
+		elbClient:   elb.New(sess),
+		elbv2Client: elbv2.New(sess),
+		includeTags: includeTags,
+		resolutionErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "urd_elb_resolution_errors_total",
+			Help: "Total number of failures resolving a Service's load balancer via DescribeLoadBalancers, by reason",
+		}, []string{"reason"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *Resolver) Describe(ch chan<- *prometheus.Desc) {
+	r.resolutionErrorsTotal.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Resolver) Collect(ch chan<- prometheus.Metric) {
+	r.resolutionErrorsTotal.Collect(ch)
+}
+
+// Sync rebuilds the resolver's hostname cache from DescribeLoadBalancers
+// (Classic and v2) plus each load balancer's tags. It returns the last
+// error encountered but still applies whatever it did manage to describe,
+// so a failure in one API doesn't blank out the other's results.
+func (r *Resolver) Sync(ctx context.Context) error {
+	byDNS := make(map[string]ResolvedLB)
+
+	var syncErr error
+	if err := r.syncClassic(ctx, byDNS); err != nil {
+		r.resolutionErrorsTotal.WithLabelValues("describe_classic").Inc()
+		syncErr = err
+	}
+	if err := r.syncV2(ctx, byDNS); err != nil {
+		r.resolutionErrorsTotal.WithLabelValues("describe_v2").Inc()
+		syncErr = err
+	}
+
+	r.mu.Lock()
+	r.byDNS = byDNS
+	r.mu.Unlock()
+
+	return syncErr
+}
+
+func (r *Resolver) syncClassic(ctx context.Context, byDNS map[string]ResolvedLB) error {
+	var names []*string
+	err := r.elbClient.DescribeLoadBalancersPagesWithContext(ctx, &elb.DescribeLoadBalancersInput{}, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range page.LoadBalancerDescriptions {
+			if lb.DNSName == nil || lb.LoadBalancerName == nil {
+				continue
+			}
+			byDNS[*lb.DNSName] = ResolvedLB{Name: *lb.LoadBalancerName, Type: TypeClassic}
+			names = append(names, lb.LoadBalancerName)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("describe classic load balancers: %v", err)
+	}
+
+	tags, err := r.classicTags(ctx, names)
+	if err != nil {
+		return err
+	}
+	for name, t := range tags {
+		for dns, resolved := range byDNS {
+			if resolved.Name == name {
+				resolved.Tags = t
+				byDNS[dns] = resolved
+			}
+		}
+	}
+	return nil
+}
+
+// classicTags batches DescribeTags calls for Classic ELBs, which accept at
+// most 20 load balancer names per call, and filters the result down to
+// r.includeTags.
+func (r *Resolver) classicTags(ctx context.Context, names []*string) (map[string]map[string]string, error) {
+	const maxNamesPerCall = 20
+
+	tags := make(map[string]map[string]string, len(names))
+	for len(names) > 0 {
+		n := maxNamesPerCall
+		if n > len(names) {
+			n = len(names)
+		}
+		batch := names[:n]
+		names = names[n:]
+
+		output, err := r.elbClient.DescribeTagsWithContext(ctx, &elb.DescribeTagsInput{LoadBalancerNames: batch})
+		if err != nil {
+			return nil, fmt.Errorf("describe classic tags: %v", err)
+		}
+		for _, td := range output.TagDescriptions {
+			if td.LoadBalancerName == nil {
+				continue
+			}
+			tags[*td.LoadBalancerName] = r.filterTags(td.Tags)
+		}
+	}
+	return tags, nil
+}
+
+func (r *Resolver) syncV2(ctx context.Context, byDNS map[string]ResolvedLB) error {
+	var arns []*string
+	err := r.elbv2Client.DescribeLoadBalancersPagesWithContext(ctx, &elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range page.LoadBalancers {
+			if lb.DNSName == nil || lb.LoadBalancerName == nil || lb.LoadBalancerArn == nil {
+				continue
+			}
+			byDNS[*lb.DNSName] = resolvedV2LB(lb)
+			arns = append(arns, lb.LoadBalancerArn)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("describe v2 load balancers: %v", err)
+	}
+
+	tags, err := r.v2Tags(ctx, arns)
+	if err != nil {
+		return err
+	}
+	for arn, t := range tags {
+		for dns, resolved := range byDNS {
+			if resolved.ARN == arn {
+				resolved.Tags = t
+				byDNS[dns] = resolved
+			}
+		}
+	}
+	return nil
+}
+
+// resolvedV2LB builds the ResolvedLB for an ALB/NLB describe result. Name
+// is the arnSuffix-shaped value CloudWatch's LoadBalancer dimension
+// expects (app/name/id, or net/name/id for NLBs), not the plain load
+// balancer name DescribeLoadBalancers also returns.
+func resolvedV2LB(lb *elbv2.LoadBalancer) ResolvedLB {
+	t := TypeALB
+	if lb.Type != nil && *lb.Type == elbv2.LoadBalancerTypeEnumNetwork {
+		t = TypeNLB
+	}
+	return ResolvedLB{
+		Name: arnSuffix(*lb.LoadBalancerArn),
+		ARN:  *lb.LoadBalancerArn,
+		Type: t,
+	}
+}
+
+// v2Tags batches DescribeTags calls for ALBs/NLBs, which accept at most 20
+// resource ARNs per call, and filters the result down to r.includeTags.
+func (r *Resolver) v2Tags(ctx context.Context, arns []*string) (map[string]map[string]string, error) {
+	const maxARNsPerCall = 20
+
+	tags := make(map[string]map[string]string, len(arns))
+	for len(arns) > 0 {
+		n := maxARNsPerCall
+		if n > len(arns) {
+			n = len(arns)
+		}
+		batch := arns[:n]
+		arns = arns[n:]
+
+		output, err := r.elbv2Client.DescribeTagsWithContext(ctx, &elbv2.DescribeTagsInput{ResourceArns: batch})
+		if err != nil {
+			return nil, fmt.Errorf("describe v2 tags: %v", err)
+		}
+		for _, td := range output.TagDescriptions {
+			if td.ResourceArn == nil {
+				continue
+			}
+			tags[*td.ResourceArn] = r.filterTags(td.Tags)
+		}
+	}
+	return tags, nil
+}
+
+// filterTags converts an AWS SDK tag list into a map, keeping only the
+// keys in r.includeTags.
+func (r *Resolver) filterTags(tags interface{}) map[string]string {
+	allow := make(map[string]bool, len(r.includeTags))
+	for _, k := range r.includeTags {
+		allow[k] = true
+	}
+
+	filtered := make(map[string]string)
+	switch tl := tags.(type) {
+	case []*elb.Tag:
+		for _, t := range tl {
+			if t.Key != nil && t.Value != nil && allow[*t.Key] {
+				filtered[*t.Key] = *t.Value
+			}
+		}
+	case []*elbv2.Tag:
+		for _, t := range tl {
+			if t.Key != nil && t.Value != nil && allow[*t.Key] {
+				filtered[*t.Key] = *t.Value
+			}
+		}
+	}
+	return filtered
+}
+
+// Resolve returns the describe-based ResolvedLB for hostname, if Sync has
+// populated it. Callers should fall back to NameFromDNS/DetectType when
+// this reports false, e.g. because Sync hasn't run yet or its last run
+// failed to see this particular load balancer.
+func (r *Resolver) Resolve(hostname string) (ResolvedLB, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resolved, ok := r.byDNS[hostname]
+	return resolved, ok
+}
+
+// SanitizeTagLabel turns an arbitrary AWS tag key into a valid Prometheus
+// label name: kubernetes.io/service-name -> tag_kubernetes_io_service_name.
+func SanitizeTagLabel(tagKey string) string {
+	var b strings.Builder
+	b.WriteString("tag_")
+	for _, r := range tagKey {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
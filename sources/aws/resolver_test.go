@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestResolverFilterTags(t *testing.T) {
+	r := &Resolver{includeTags: []string{"Environment", "Team"}}
+
+	t.Run("classic tags", func(t *testing.T) {
+		got := r.filterTags([]*elb.Tag{
+			{Key: awssdk.String("Environment"), Value: awssdk.String("prod")},
+			{Key: awssdk.String("kubernetes.io/service-name"), Value: awssdk.String("default/web")},
+		})
+		want := map[string]string{"Environment": "prod"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("filterTags() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("v2 tags", func(t *testing.T) {
+		got := r.filterTags([]*elbv2.Tag{
+			{Key: awssdk.String("Team"), Value: awssdk.String("platform")},
+			{Key: awssdk.String("Unlisted"), Value: awssdk.String("x")},
+		})
+		want := map[string]string{"Team": "platform"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("filterTags() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		got := r.filterTags("not a tag list")
+		if len(got) != 0 {
+			t.Errorf("filterTags() = %v, want empty", got)
+		}
+	})
+}
+
+func TestSanitizeTagLabel(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"Environment", "tag_Environment"},
+		{"kubernetes.io/service-name", "tag_kubernetes_io_service_name"},
+		{"a.b-c/d e", "tag_a_b_c_d_e"},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeTagLabel(tt.key); got != tt.want {
+			t.Errorf("SanitizeTagLabel(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestResolvedV2LB(t *testing.T) {
+	t.Run("alb", func(t *testing.T) {
+		got := resolvedV2LB(&elbv2.LoadBalancer{
+			LoadBalancerName: awssdk.String("my-alb"),
+			LoadBalancerArn:  awssdk.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188"),
+		})
+		want := ResolvedLB{Name: "app/my-alb/50dc6c495c0c9188", ARN: "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188", Type: TypeALB}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("resolvedV2LB() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("nlb", func(t *testing.T) {
+		nlbType := elbv2.LoadBalancerTypeEnumNetwork
+		got := resolvedV2LB(&elbv2.LoadBalancer{
+			LoadBalancerName: awssdk.String("my-nlb"),
+			LoadBalancerArn:  awssdk.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/my-nlb/50dc6c495c0c9188"),
+			Type:             &nlbType,
+		})
+		want := ResolvedLB{Name: "net/my-nlb/50dc6c495c0c9188", ARN: "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/my-nlb/50dc6c495c0c9188", Type: TypeNLB}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("resolvedV2LB() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestResolverResolve(t *testing.T) {
+	r := &Resolver{byDNS: map[string]ResolvedLB{
+		"my-lb-123456.us-east-1.elb.amazonaws.com": {Name: "my-lb", Type: TypeClassic},
+	}}
+
+	if _, ok := r.Resolve("unknown-host"); ok {
+		t.Error("Resolve() for unknown host reported ok, want not ok")
+	}
+
+	got, ok := r.Resolve("my-lb-123456.us-east-1.elb.amazonaws.com")
+	if !ok {
+		t.Fatal("Resolve() reported not ok, want ok")
+	}
+	if got.Name != "my-lb" || got.Type != TypeClassic {
+		t.Errorf("Resolve() = %+v, want Name=my-lb Type=%s", got, TypeClassic)
+	}
+}
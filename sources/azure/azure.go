@@ -0,0 +1,135 @@
+// Package azure implements sources.MetricSource against Azure Monitor for
+// AKS Services backed by an Azure Application Gateway or Load Balancer.
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/monitor/mgmt/2018-01-01/insights"
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/erickfaustino/urd/sources"
+)
+
+// The sources.LoadBalancer.Type values this package understands.
+const (
+	TypeApplicationGateway = "app-gateway"
+	TypeStandard           = "standard"
+)
+
+// appGatewayAnnotationPrefix marks ingresses managed by the Application
+// Gateway Ingress Controller (AGIC); its presence is how we tell an
+// Application Gateway-backed Service apart from one behind Azure's
+// standard Load Balancer.
+const appGatewayAnnotationPrefix = "appgw.ingress.kubernetes.io/"
+
+// DetectType reports whether a Service's Azure load balancer is an
+// Application Gateway (fronted by AGIC) or Azure's standard Load Balancer.
+func DetectType(annotations map[string]string) string {
+	for k := range annotations {
+		if strings.HasPrefix(k, appGatewayAnnotationPrefix) {
+			return TypeApplicationGateway
+		}
+	}
+	return TypeStandard
+}
+
+// metricTable maps a canonical metric key to the Azure Monitor metric name
+// that backs it. Azure's Application Gateway metrics only cover a subset
+// of what CloudWatch exposes for AWS ELBs, so unmapped keys are simply
+// unsupported here.
+var metricTable = map[sources.MetricKey]string{
+	sources.MetricRequestCount:       "RequestCount",
+	sources.MetricHTTP2xx:            "ResponseStatus", // filtered by dimension below
+	sources.MetricHealthyHostCount:   "HealthyHostCount",
+	sources.MetricUnhealthyHostCount: "UnhealthyHostCount",
+	sources.MetricLatency:            "BackendLastByteResponseTime",
+}
+
+// Source is a sources.MetricSource backed by Azure Monitor. It also owns
+// the Resolver that turns a Service's load balancer IP into the Azure
+// resource ID Azure Monitor's API expects.
+type Source struct {
+	client         insights.MetricsClient
+	subscriptionID string
+	Resolver       *Resolver
+}
+
+// New creates an Azure Monitor-backed Source, authenticating from the
+// environment via the Azure SDK's standard auth helpers. nodeResourceGroup
+// is AKS's node resource group (the "MC_*" one), which is where the
+// Resolver looks for the public IPs backing a Service's load balancer.
+func New(subscriptionID, nodeResourceGroup string) (*Source, error) {
+	authorizer, err := autorest.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return nil, fmt.Errorf("azure authorizer: %v", err)
+	}
+
+	client := insights.NewMetricsClient(subscriptionID)
+	client.Authorizer = authorizer
+	return &Source{
+		client:         client,
+		subscriptionID: subscriptionID,
+		Resolver:       NewResolver(subscriptionID, nodeResourceGroup, authorizer),
+	}, nil
+}
+
+// Supports implements sources.MetricSource.
+func (s *Source) Supports(lb sources.LoadBalancer) bool {
+	return lb.Provider == sources.ProviderAzure
+}
+
+// Metrics implements sources.MetricLister. Azure Monitor's Application
+// Gateway metrics aren't split by LoadBalancer.Type, so lbType is unused:
+// every key in metricTable applies regardless of subtype.
+func (s *Source) Metrics(lbType string) []sources.MetricKey {
+	keys := make([]sources.MetricKey, 0, len(metricTable))
+	for k := range metricTable {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Fetch implements sources.MetricSource. lb.Name is expected to be the
+// full Azure resource ID of the Application Gateway or Load Balancer.
+func (s *Source) Fetch(ctx context.Context, lb sources.LoadBalancer, metricKey sources.MetricKey, start, end time.Time) (float64, error) {
+	metricName, ok := metricTable[metricKey]
+	if !ok {
+		return 0, fmt.Errorf("azure: unsupported metric %q", metricKey)
+	}
+
+	var filter string
+	if metricKey == sources.MetricHTTP2xx {
+		filter = "HttpStatusGroup eq '2xx'"
+	}
+
+	timespan := fmt.Sprintf("%s/%s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	result, err := s.client.List(ctx, lb.Name, timespan, nil, metricName, "Total", nil, "", filter, insights.Data, "")
+	if err != nil {
+		return 0, fmt.Errorf("azure: list metrics: %v", err)
+	}
+
+	var total float64
+	if result.Value == nil {
+		return 0, nil
+	}
+	for _, m := range *result.Value {
+		if m.Timeseries == nil {
+			continue
+		}
+		for _, series := range *m.Timeseries {
+			if series.Data == nil {
+				continue
+			}
+			for _, point := range *series.Data {
+				if point.Total != nil {
+					total += *point.Total
+				}
+			}
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,49 @@
+package azure
+
+import "testing"
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "no annotations", want: TypeStandard},
+		{name: "unrelated annotation", annotations: map[string]string{"foo": "bar"}, want: TypeStandard},
+		{name: "app gateway annotation", annotations: map[string]string{"appgw.ingress.kubernetes.io/backend-protocol": "https"}, want: TypeApplicationGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectType(tt.annotations); got != tt.want {
+				t.Errorf("DetectType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrontendOwnerID(t *testing.T) {
+	tests := []struct {
+		ipConfigID string
+		want       string
+	}{
+		{
+			ipConfigID: "/subscriptions/s/resourceGroups/MC_rg/providers/Microsoft.Network/loadBalancers/my-lb/frontendIPConfigurations/my-config",
+			want:       "/subscriptions/s/resourceGroups/MC_rg/providers/Microsoft.Network/loadBalancers/my-lb",
+		},
+		{
+			ipConfigID: "/subscriptions/s/resourceGroups/MC_rg/providers/Microsoft.Network/applicationGateways/my-agw/frontendIPConfigurations/my-config",
+			want:       "/subscriptions/s/resourceGroups/MC_rg/providers/Microsoft.Network/applicationGateways/my-agw",
+		},
+		{
+			ipConfigID: "no-marker-here",
+			want:       "no-marker-here",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := frontendOwnerID(tt.ipConfigID); got != tt.want {
+			t.Errorf("frontendOwnerID(%q) = %q, want %q", tt.ipConfigID, got, tt.want)
+		}
+	}
+}
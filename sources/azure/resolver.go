@@ -0,0 +1,87 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-02-01/network"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Resolver maps a Service's load balancer IP to the full Azure resource ID
+// Azure Monitor's metrics API expects. AKS provisions Application Gateways
+// and Load Balancers into its node resource group (the "MC_*" group), not
+// the cluster's own resource group, so the Resolver lists that group's
+// public IP addresses and walks each one back to the resource ID of the
+// frontend it belongs to.
+type Resolver struct {
+	publicIPClient network.PublicIPAddressesClient
+	resourceGroup  string
+
+	mu   sync.RWMutex
+	byIP map[string]string
+}
+
+// NewResolver creates a Resolver that looks up public IPs in
+// nodeResourceGroup, the resource group AKS manages its load balancer
+// infrastructure in.
+func NewResolver(subscriptionID, nodeResourceGroup string, authorizer autorest.Authorizer) *Resolver {
+	client := network.NewPublicIPAddressesClient(subscriptionID)
+	client.Authorizer = authorizer
+	return &Resolver{
+		publicIPClient: client,
+		resourceGroup:  nodeResourceGroup,
+	}
+}
+
+// Sync rebuilds the resolver's IP cache from the node resource group's
+// public IP addresses.
+func (r *Resolver) Sync(ctx context.Context) error {
+	byIP := make(map[string]string)
+
+	page, err := r.publicIPClient.List(ctx, r.resourceGroup)
+	if err != nil {
+		return fmt.Errorf("list public IPs: %v", err)
+	}
+	for page.NotDone() {
+		for _, ip := range page.Values() {
+			if ip.PublicIPAddressPropertiesFormat == nil || ip.IPAddress == nil {
+				continue
+			}
+			if ip.IPConfiguration == nil || ip.IPConfiguration.ID == nil {
+				continue
+			}
+			byIP[*ip.IPAddress] = frontendOwnerID(*ip.IPConfiguration.ID)
+		}
+		if err := page.NextWithContext(ctx); err != nil {
+			return fmt.Errorf("list public IPs: %v", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.byIP = byIP
+	r.mu.Unlock()
+	return nil
+}
+
+// frontendOwnerID strips a frontend IP configuration's resource ID down to
+// the resource ID of the Load Balancer or Application Gateway that owns it.
+// .../loadBalancers/my-lb/frontendIPConfigurations/my-config -> .../loadBalancers/my-lb
+func frontendOwnerID(ipConfigID string) string {
+	for _, marker := range []string{"/frontendIPConfigurations/", "/frontendIpConfigurations/"} {
+		if i := strings.Index(ipConfigID, marker); i >= 0 {
+			return ipConfigID[:i]
+		}
+	}
+	return ipConfigID
+}
+
+// Resolve returns the owning resource ID for ip, if Sync has seen it.
+func (r *Resolver) Resolve(ip string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byIP[ip]
+	return id, ok
+}
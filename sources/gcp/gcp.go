@@ -0,0 +1,134 @@
+// Package gcp implements sources.MetricSource against GCP Cloud Monitoring
+// (Stackdriver) for GKE Services backed by a GCP HTTP(S) or TCP/UDP load
+// balancer.
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"google.golang.org/api/iterator"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/erickfaustino/urd/sources"
+)
+
+// The sources.LoadBalancer.Type values this package understands.
+const (
+	TypeHTTPS = "https"
+	TypeTCP   = "tcp"
+)
+
+// internalAnnotation is set by GKE's service controller on Services that
+// got an internal TCP/UDP load balancer rather than an external HTTP(S)
+// one.
+const internalAnnotation = "cloud.google.com/load-balancer-type"
+
+// DetectType reports whether a Service's GCP load balancer is an external
+// HTTP(S) Load Balancer or an internal TCP/UDP Load Balancer.
+func DetectType(annotations map[string]string) string {
+	if strings.EqualFold(annotations[internalAnnotation], "Internal") {
+		return TypeTCP
+	}
+	return TypeHTTPS
+}
+
+// metricTable maps a canonical metric key to the Cloud Monitoring metric
+// type that backs it. GCP's load balancing metrics only cover a subset of
+// what CloudWatch exposes for AWS ELBs, so unmapped keys are simply
+// unsupported here.
+var metricTable = map[sources.MetricKey]string{
+	sources.MetricRequestCount:       "loadbalancing.googleapis.com/https/request_count",
+	sources.MetricHTTP2xx:            "loadbalancing.googleapis.com/https/request_count", // filtered by response_code_class below
+	sources.MetricLatency:            "loadbalancing.googleapis.com/https/total_latencies",
+	sources.MetricHealthyHostCount:   "loadbalancing.googleapis.com/https/backend_request_count",
+	sources.MetricUnhealthyHostCount: "loadbalancing.googleapis.com/https/backend_request_count",
+}
+
+// Source is a sources.MetricSource backed by the Cloud Monitoring API. It
+// also owns the Resolver that turns a Service's load balancer IP into the
+// resource name Cloud Monitoring's filters expect.
+type Source struct {
+	client   *monitoring.MetricClient
+	project  string
+	Resolver *Resolver
+}
+
+// New creates a Cloud Monitoring-backed Source for the given GCP project.
+func New(ctx context.Context, project string) (*Source, error) {
+	client, err := monitoring.NewMetricClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new monitoring client: %v", err)
+	}
+	resolver, err := NewResolver(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("new resolver: %v", err)
+	}
+	return &Source{client: client, project: project, Resolver: resolver}, nil
+}
+
+// Supports implements sources.MetricSource.
+func (s *Source) Supports(lb sources.LoadBalancer) bool {
+	return lb.Provider == sources.ProviderGCP
+}
+
+// Metrics implements sources.MetricLister. Cloud Monitoring's load
+// balancing series aren't split by LoadBalancer.Type the way CloudWatch's
+// are, so lbType is unused: every key in metricTable applies to both the
+// HTTPS and TCP cases.
+func (s *Source) Metrics(lbType string) []sources.MetricKey {
+	keys := make([]sources.MetricKey, 0, len(metricTable))
+	for k := range metricTable {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// filterFor builds the Cloud Monitoring filter expression for a metric
+// type, scoped to the given url_map_name (lb.Name, as resolved by Resolver).
+func filterFor(metricType, urlMapName string) string {
+	return fmt.Sprintf(`metric.type="%s" AND resource.label.url_map_name="%s"`, metricType, urlMapName)
+}
+
+// Fetch implements sources.MetricSource.
+func (s *Source) Fetch(ctx context.Context, lb sources.LoadBalancer, metricKey sources.MetricKey, start, end time.Time) (float64, error) {
+	metricType, ok := metricTable[metricKey]
+	if !ok {
+		return 0, fmt.Errorf("gcp: unsupported metric %q", metricKey)
+	}
+
+	filter := filterFor(metricType, lb.Name)
+	if metricKey == sources.MetricHTTP2xx {
+		filter += ` AND metric.label.response_code_class=200`
+	}
+
+	req := &monitoringpb.ListTimeSeriesRequest{
+		Name:   fmt.Sprintf("projects/%s", s.project),
+		Filter: filter,
+		Interval: &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(start),
+			EndTime:   timestamppb.New(end),
+		},
+		View: monitoringpb.ListTimeSeriesRequest_FULL,
+	}
+
+	it := s.client.ListTimeSeries(ctx, req)
+	var total float64
+	for {
+		series, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("gcp: list time series: %v", err)
+		}
+		for _, point := range series.Points {
+			total += point.Value.GetDoubleValue()
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,49 @@
+package gcp
+
+import "testing"
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "external HTTPS, no annotation", want: TypeHTTPS},
+		{name: "internal", annotations: map[string]string{internalAnnotation: "Internal"}, want: TypeTCP},
+		{name: "internal, case-insensitive", annotations: map[string]string{internalAnnotation: "internal"}, want: TypeTCP},
+		{name: "unrelated annotation value", annotations: map[string]string{internalAnnotation: "External"}, want: TypeHTTPS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectType(tt.annotations); got != tt.want {
+				t.Errorf("DetectType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterFor(t *testing.T) {
+	got := filterFor("loadbalancing.googleapis.com/https/request_count", "my-url-map")
+	want := `metric.type="loadbalancing.googleapis.com/https/request_count" AND resource.label.url_map_name="my-url-map"`
+	if got != want {
+		t.Errorf("filterFor() = %q, want %q", got, want)
+	}
+}
+
+func TestLastPathSegment(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.googleapis.com/compute/v1/projects/p/global/urlMaps/my-map", "my-map"},
+		{"https://www.googleapis.com/compute/v1/projects/p/global/targetHttpProxies/my-proxy", "my-proxy"},
+		{"no-slashes", "no-slashes"},
+	}
+
+	for _, tt := range tests {
+		if got := lastPathSegment(tt.url); got != tt.want {
+			t.Errorf("lastPathSegment(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Resolver maps a GCP load balancer's IP address to the resource name
+// Cloud Monitoring's url_map_name filter expects. Cloud Monitoring has no
+// API to go from an IP straight to that name, so the Resolver walks
+// forwarding rules -> target proxies -> URL maps (for external HTTP(S) LBs)
+// and forwarding rules -> backend services (for internal TCP/UDP LBs) once
+// per Sync and caches the result.
+type Resolver struct {
+	computeSvc *compute.Service
+	project    string
+
+	mu   sync.RWMutex
+	byIP map[string]string
+}
+
+// NewResolver creates a Resolver backed by the Compute API.
+func NewResolver(ctx context.Context, project string) (*Resolver, error) {
+	svc, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new compute service: %v", err)
+	}
+	return &Resolver{computeSvc: svc, project: project}, nil
+}
+
+// Sync rebuilds the resolver's IP cache from the project's global (HTTP(S))
+// and regional (internal TCP/UDP) forwarding rules.
+func (r *Resolver) Sync(ctx context.Context) error {
+	byIP := make(map[string]string)
+
+	if err := r.syncGlobal(ctx, byIP); err != nil {
+		return err
+	}
+	if err := r.syncRegional(ctx, byIP); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.byIP = byIP
+	r.mu.Unlock()
+	return nil
+}
+
+// syncGlobal resolves external HTTP(S) Load Balancer IPs to their URL map
+// name via the forwarding rule's target proxy.
+func (r *Resolver) syncGlobal(ctx context.Context, byIP map[string]string) error {
+	err := r.computeSvc.GlobalForwardingRules.List(r.project).Pages(ctx, func(page *compute.ForwardingRuleList) error {
+		for _, rule := range page.Items {
+			if rule.IPAddress == "" || rule.Target == "" {
+				continue
+			}
+			name, err := r.urlMapName(rule.Target)
+			if err != nil {
+				continue
+			}
+			byIP[rule.IPAddress] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list global forwarding rules: %v", err)
+	}
+	return nil
+}
+
+// urlMapName follows a target HTTP(S) proxy URL back to the URL map name
+// Cloud Monitoring filters on.
+func (r *Resolver) urlMapName(targetProxyURL string) (string, error) {
+	proxyName := lastPathSegment(targetProxyURL)
+
+	if proxy, err := r.computeSvc.TargetHttpsProxies.Get(r.project, proxyName).Do(); err == nil {
+		return lastPathSegment(proxy.UrlMap), nil
+	}
+	proxy, err := r.computeSvc.TargetHttpProxies.Get(r.project, proxyName).Do()
+	if err != nil {
+		return "", fmt.Errorf("get target proxy %q: %v", proxyName, err)
+	}
+	return lastPathSegment(proxy.UrlMap), nil
+}
+
+// syncRegional resolves internal TCP/UDP Load Balancer IPs to their backend
+// service name.
+func (r *Resolver) syncRegional(ctx context.Context, byIP map[string]string) error {
+	err := r.computeSvc.ForwardingRules.AggregatedList(r.project).Pages(ctx, func(page *compute.ForwardingRuleAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, rule := range scoped.ForwardingRules {
+				if rule.IPAddress == "" || rule.BackendService == "" {
+					continue
+				}
+				byIP[rule.IPAddress] = lastPathSegment(rule.BackendService)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list regional forwarding rules: %v", err)
+	}
+	return nil
+}
+
+// Resolve returns the url_map_name/backend-service name for ip, if Sync has
+// seen it.
+func (r *Resolver) Resolve(ip string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.byIP[ip]
+	return name, ok
+}
+
+// lastPathSegment returns the last "/"-separated segment of a Compute API
+// resource URL, e.g. ".../global/urlMaps/my-map" -> "my-map".
+func lastPathSegment(url string) string {
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		return url[i+1:]
+	}
+	return url
+}
@@ -0,0 +1,137 @@
+// Package sources defines the cloud-agnostic contract urd's collector uses
+// to fetch load balancer metrics. Each supported cloud implements
+// MetricSource against its own monitoring API; the collector picks the
+// right one per Service and stays ignorant of CloudWatch/Stackdriver/Azure
+// Monitor specifics.
+package sources
+
+import (
+	"context"
+	"time"
+)
+
+// Provider identifies which cloud operates a LoadBalancer.
+type Provider string
+
+const (
+	ProviderAWS   Provider = "aws"
+	ProviderGCP   Provider = "gcp"
+	ProviderAzure Provider = "azure"
+)
+
+// MetricKey names a metric in cloud-agnostic terms. Each MetricSource maps
+// a MetricKey to whatever its backend calls that metric.
+type MetricKey string
+
+const (
+	MetricRequestCount        MetricKey = "request_count"
+	MetricHTTP2xx             MetricKey = "http_2xx"
+	MetricHTTP3xx             MetricKey = "http_3xx"
+	MetricHTTP4xx             MetricKey = "http_4xx"
+	MetricHTTP5xx             MetricKey = "http_5xx"
+	MetricBackendErrors       MetricKey = "backend_errors"
+	MetricHealthyHostCount    MetricKey = "healthy_host_count"
+	MetricUnhealthyHostCount  MetricKey = "unhealthy_host_count"
+	MetricLatency             MetricKey = "latency"
+	MetricSpilloverCount      MetricKey = "spillover_count"
+	MetricSurgeQueueLength    MetricKey = "surge_queue_length"
+	MetricRejectedConnections MetricKey = "rejected_connections"
+	MetricActiveFlowCount     MetricKey = "active_flow_count"
+	MetricNewFlowCount        MetricKey = "new_flow_count"
+	MetricProcessedBytes      MetricKey = "processed_bytes"
+	MetricTCPResetClient      MetricKey = "tcp_reset_client"
+	MetricTCPResetELB         MetricKey = "tcp_reset_elb"
+	MetricTCPResetTarget      MetricKey = "tcp_reset_target"
+	MetricELB4xx              MetricKey = "elb_4xx"
+	MetricELB5xx              MetricKey = "elb_5xx"
+)
+
+// AllMetricKeys returns every canonical MetricKey urd knows how to publish.
+// The collector uses this as the query list for a MetricSource that doesn't
+// implement MetricLister, trading a few doomed-to-be-unsupported Fetch
+// calls for not having to special-case sourceless discovery.
+func AllMetricKeys() []MetricKey {
+	return []MetricKey{
+		MetricRequestCount,
+		MetricHTTP2xx,
+		MetricHTTP3xx,
+		MetricHTTP4xx,
+		MetricHTTP5xx,
+		MetricELB4xx,
+		MetricELB5xx,
+		MetricBackendErrors,
+		MetricHealthyHostCount,
+		MetricUnhealthyHostCount,
+		MetricLatency,
+		MetricSpilloverCount,
+		MetricSurgeQueueLength,
+		MetricRejectedConnections,
+		MetricActiveFlowCount,
+		MetricNewFlowCount,
+		MetricProcessedBytes,
+		MetricTCPResetClient,
+		MetricTCPResetELB,
+		MetricTCPResetTarget,
+	}
+}
+
+// LoadBalancer is the cloud-agnostic view of a Kubernetes Service backed by
+// a cloud load balancer: enough for a MetricSource to locate the
+// underlying resource and for the collector to label the metrics it
+// returns.
+type LoadBalancer struct {
+	// Name is the cloud resource name/ARN-ish identifier a MetricSource
+	// resolves metrics against (e.g. the ELB name, or a GCP/Azure
+	// resource name).
+	Name string
+	// Type is a provider-specific subtype such as "classic"/"alb"/"nlb"
+	// on AWS, used both by the MetricSource and as the urd lb_type label.
+	Type string
+	// SvcName and Namespace are the backing Kubernetes Service's
+	// coordinates, carried through purely for Prometheus labels.
+	SvcName   string
+	Namespace string
+	Provider  Provider
+	// Annotations is the Service's annotation set, so a MetricSource can
+	// read provider-specific hints.
+	Annotations map[string]string
+	// Tags is the load balancer's cloud resource tags, filtered down to
+	// whatever allow-list the MetricSource's resolver was configured
+	// with, so the collector can turn them into extra Prometheus labels.
+	Tags map[string]string
+}
+
+// Query is one (LoadBalancer, MetricKey) pair a MetricSource is asked to
+// resolve, tagged with a caller-assigned ID so batch results can be
+// demultiplexed back to their caller.
+type Query struct {
+	ID     string
+	LB     LoadBalancer
+	Metric MetricKey
+}
+
+// MetricSource fetches datapoints for a LoadBalancer's metrics from a
+// specific cloud monitoring backend.
+type MetricSource interface {
+	// Supports reports whether this source owns metrics for lb.
+	Supports(lb LoadBalancer) bool
+	// Fetch returns the latest datapoint for metric over [start, end].
+	Fetch(ctx context.Context, lb LoadBalancer, metric MetricKey, start, end time.Time) (float64, error)
+}
+
+// BatchFetcher is an optional capability a MetricSource can implement when
+// its backend supports resolving many metrics in a single round trip (as
+// CloudWatch's GetMetricData does). The collector prefers this path and
+// falls back to one Fetch call per query when a source doesn't implement
+// it.
+type BatchFetcher interface {
+	FetchBatch(ctx context.Context, queries []Query, start, end time.Time) (map[string]float64, error)
+}
+
+// MetricLister is an optional capability a MetricSource can implement to
+// tell the collector which canonical metrics it can resolve for a given
+// LoadBalancer.Type, so the collector only asks for metrics the backend
+// actually publishes instead of probing the full AllMetricKeys set.
+type MetricLister interface {
+	Metrics(lbType string) []MetricKey
+}